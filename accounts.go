@@ -0,0 +1,73 @@
+package mysqltest
+
+import "fmt"
+
+// Account describes a MySQL user to provision once the server is ready for
+// connections, in addition to the default passwordless root.
+type Account struct {
+	User     string
+	Password string
+	// Host is the account's host pattern, e.g. "localhost" or "%". It
+	// defaults to "%".
+	Host string
+	// AuthPlugin is the authentication plugin to create the account with,
+	// e.g. "mysql_native_password" or "caching_sha2_password". It defaults
+	// to "mysql_native_password".
+	AuthPlugin string
+	// Grants are GRANT statement bodies applied to the account, e.g.
+	// "ALL PRIVILEGES ON testdb.*". Each entry gets "TO 'user'@'host'"
+	// appended and executed on its own.
+	Grants []string
+}
+
+func (a Account) host() string {
+	if a.Host == "" {
+		return "%"
+	}
+	return a.Host
+}
+
+func (a Account) authPlugin() string {
+	if a.AuthPlugin == "" {
+		return "mysql_native_password"
+	}
+	return a.AuthPlugin
+}
+
+// createAccounts provisions s.Accounts against the running server using the
+// default root connection.
+func (s *Server) createAccounts() error {
+	if len(s.Accounts) == 0 {
+		return nil
+	}
+
+	db, err := s.rootDB("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, a := range s.Accounts {
+		stmt := fmt.Sprintf("CREATE USER '%s'@'%s' IDENTIFIED WITH %s BY '%s'", a.User, a.host(), a.authPlugin(), a.Password)
+		if _, err := db.Exec(stmt); err != nil {
+			return fmt.Errorf("creating account %s@%s: %v", a.User, a.host(), err)
+		}
+		for _, grant := range a.Grants {
+			stmt := fmt.Sprintf("GRANT %s TO '%s'@'%s'", grant, a.User, a.host())
+			if _, err := db.Exec(stmt); err != nil {
+				return fmt.Errorf("granting %q to %s@%s: %v", grant, a.User, a.host(), err)
+			}
+		}
+	}
+
+	if _, err := db.Exec("FLUSH PRIVILEGES"); err != nil {
+		return fmt.Errorf("flushing privileges: %v", err)
+	}
+	return nil
+}
+
+// DSNFor is a DSN for account a against this server, suitable for use with
+// sql.Open. The suffix is in the form "dbname?param=value".
+func (s *Server) DSNFor(a Account, suffix string) string {
+	return fmt.Sprintf("%s:%s@tcp(127.0.0.1:%d)/%s", a.User, a.Password, s.Port, suffix)
+}
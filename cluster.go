@@ -0,0 +1,272 @@
+package mysqltest
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// Topology configures NewCluster.
+type Topology struct {
+	// Replicas is the number of replica Servers to start in addition to
+	// the primary. Defaults to 1.
+	Replicas int
+	// New constructs each Server (primary and replicas alike) before it's
+	// started, so callers can set Flavor, Version, etc. It defaults to
+	// &Server{}.
+	New func() *Server
+}
+
+func (t Topology) replicas() int {
+	if t.Replicas > 0 {
+		return t.Replicas
+	}
+	return 1
+}
+
+func (t Topology) newServer() *Server {
+	if t.New != nil {
+		return t.New()
+	}
+	return &Server{}
+}
+
+// Cluster is a primary Server plus N replicas wired up via replication.
+type Cluster struct {
+	primary  *Server
+	replicas []*Server
+}
+
+// NewCluster starts a primary (configured for log-bin, server-id, and
+// binlog_format=ROW) and topo.replicas() replicas, then wires each replica
+// to the primary. On MySQL new enough to support GTID (see useGTID) this
+// uses GTID auto-positioning instead of an explicit binlog file+position;
+// otherwise it uses the primary's current binlog file+position. The
+// statement is CHANGE REPLICATION SOURCE TO on MySQL 8.0.23+ and CHANGE
+// MASTER TO everywhere else.
+func NewCluster(t Fatalf, topo Topology) *Cluster {
+	primary := topo.newServer()
+	primary.T = t
+	primary.ServerID = 1
+	primary.LogBin = true
+	primary.GTID = useGTID(primary)
+	primary.Start()
+
+	c := &Cluster{primary: primary}
+	for i := 0; i < topo.replicas(); i++ {
+		replica := topo.newServer()
+		replica.T = t
+		replica.ServerID = i + 2
+		replica.GTID = primary.GTID
+		replica.Start()
+
+		if err := startReplication(replica, primary); err != nil {
+			t.Fatalf(err.Error())
+		}
+		c.replicas = append(c.replicas, replica)
+	}
+	return c
+}
+
+// Primary returns the cluster's primary Server.
+func (c *Cluster) Primary() *Server { return c.primary }
+
+// Replicas returns the cluster's replica Servers.
+func (c *Cluster) Replicas() []*Server { return c.replicas }
+
+// WaitForReplication blocks until every replica reports zero seconds
+// behind the primary, or ctx is done.
+func (c *Cluster) WaitForReplication(ctx context.Context) error {
+	for _, replica := range c.replicas {
+		if err := waitForReplicaCaughtUp(ctx, replica); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replKeywords holds the CHANGE ... TO option names for a given
+// flavor/version, since MySQL 8.0.23+ renamed the classic MASTER/SLAVE
+// terminology to SOURCE/REPLICA while MariaDB and older MySQL kept it.
+type replKeywords struct {
+	change, host, port, user, logFile, logPos, start, status, autoPosition string
+}
+
+func replicationKeywordsFor(primary *Server) replKeywords {
+	if (primary.Flavor == MySQL || primary.Flavor == "") && mysqlUsesSourceReplicaTerms(primary.Version) {
+		return replKeywords{
+			change:       "CHANGE REPLICATION SOURCE TO",
+			host:         "SOURCE_HOST",
+			port:         "SOURCE_PORT",
+			user:         "SOURCE_USER",
+			logFile:      "SOURCE_LOG_FILE",
+			logPos:       "SOURCE_LOG_POS",
+			start:        "START REPLICA",
+			status:       "SHOW REPLICA STATUS",
+			autoPosition: "SOURCE_AUTO_POSITION",
+		}
+	}
+	return replKeywords{
+		change:       "CHANGE MASTER TO",
+		host:         "MASTER_HOST",
+		port:         "MASTER_PORT",
+		user:         "MASTER_USER",
+		logFile:      "MASTER_LOG_FILE",
+		logPos:       "MASTER_LOG_POS",
+		start:        "START SLAVE",
+		status:       "SHOW SLAVE STATUS",
+		autoPosition: "MASTER_AUTO_POSITION",
+	}
+}
+
+// mysqlUsesSourceReplicaTerms reports whether version (e.g. "8.0.23") is
+// new enough that MySQL uses CHANGE REPLICATION SOURCE TO / SHOW REPLICA
+// STATUS instead of the classic CHANGE MASTER TO / SHOW SLAVE STATUS. An
+// empty version is assumed to predate the rename.
+func mysqlUsesSourceReplicaTerms(version string) bool {
+	if version == "" {
+		return false
+	}
+	var major, minor, patch int
+	if _, err := fmt.Sscanf(version, "%d.%d.%d", &major, &minor, &patch); err != nil {
+		return false
+	}
+	return major > 8 || (major == 8 && (minor > 0 || patch >= 23))
+}
+
+// useGTID reports whether primary should be started with GTID mode
+// enabled and its replicas wired up via AUTO_POSITION instead of an
+// explicit binlog file+position. Only MySQL is supported; MariaDB's GTID
+// implementation uses different syntax (MASTER_USE_GTID) and isn't wired
+// up here.
+func useGTID(primary *Server) bool {
+	return (primary.Flavor == MySQL || primary.Flavor == "") && mysqlSupportsGTID(primary.Version)
+}
+
+// mysqlSupportsGTID reports whether version (e.g. "8.0.23") is new enough
+// for GTID-based replication, which MySQL has supported since 5.6. An
+// empty version is assumed to support it.
+func mysqlSupportsGTID(version string) bool {
+	if version == "" {
+		return true
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return true
+	}
+	return major > 5 || (major == 5 && minor >= 6)
+}
+
+// startReplication points replica at primary, using GTID auto-positioning
+// when primary.GTID is set or else primary's current binlog file+position,
+// and starts replication.
+func startReplication(replica, primary *Server) error {
+	k := replicationKeywordsFor(primary)
+
+	replicaDB, err := replica.rootDB("")
+	if err != nil {
+		return err
+	}
+	defer replicaDB.Close()
+
+	var stmt string
+	if primary.GTID {
+		stmt = fmt.Sprintf(
+			"%s %s='127.0.0.1', %s=%d, %s='root', %s=1",
+			k.change, k.host, k.port, primary.Port, k.user, k.autoPosition,
+		)
+	} else {
+		primaryDB, err := primary.rootDB("")
+		if err != nil {
+			return err
+		}
+		defer primaryDB.Close()
+
+		var file string
+		var pos int64
+		var doDB, ignoreDB, gtidSet sql.RawBytes
+		dest := []interface{}{&file, &pos, &doDB, &ignoreDB, &gtidSet}
+		if primary.Flavor == MariaDB {
+			// MariaDB's SHOW MASTER STATUS has no Executed_Gtid_Set column.
+			dest = dest[:4]
+		}
+		row := primaryDB.QueryRow("SHOW MASTER STATUS")
+		if err := row.Scan(dest...); err != nil {
+			return fmt.Errorf("reading primary status: %v", err)
+		}
+
+		stmt = fmt.Sprintf(
+			"%s %s='127.0.0.1', %s=%d, %s='root', %s='%s', %s=%d",
+			k.change, k.host, k.port, primary.Port, k.user, k.logFile, file, k.logPos, pos,
+		)
+	}
+
+	if _, err := replicaDB.Exec(stmt); err != nil {
+		return fmt.Errorf("configuring replication on %s: %v", replica.DataDir, err)
+	}
+	if _, err := replicaDB.Exec(k.start); err != nil {
+		return fmt.Errorf("starting replication on %s: %v", replica.DataDir, err)
+	}
+	return nil
+}
+
+func waitForReplicaCaughtUp(ctx context.Context, replica *Server) error {
+	db, err := replica.rootDB("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	status := replicationKeywordsFor(replica).status
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		caughtUp, err := replicaCaughtUp(db, status)
+		if err != nil {
+			return err
+		}
+		if caughtUp {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// replicaCaughtUp runs stmt (SHOW [REPLICA|SLAVE] STATUS) and reports
+// whether its Seconds_Behind_Source/Seconds_Behind_Master column is 0.
+func replicaCaughtUp(db *sql.DB, stmt string) (bool, error) {
+	rows, err := db.Query(stmt)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return false, err
+	}
+	if !rows.Next() {
+		return false, rows.Err()
+	}
+
+	vals := make([]sql.RawBytes, len(cols))
+	scanArgs := make([]interface{}, len(cols))
+	for i := range vals {
+		scanArgs[i] = &vals[i]
+	}
+	if err := rows.Scan(scanArgs...); err != nil {
+		return false, err
+	}
+
+	for i, col := range cols {
+		if col == "Seconds_Behind_Source" || col == "Seconds_Behind_Master" {
+			return string(vals[i]) == "0", nil
+		}
+	}
+	return false, fmt.Errorf("no Seconds_Behind_* column in %q output", stmt)
+}
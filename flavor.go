@@ -0,0 +1,94 @@
+package mysqltest
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sync"
+)
+
+// Flavor identifies the server implementation a Server should run. mysqld
+// binaries from different vendors differ in bootstrap commands, startup
+// banners, and (for older MariaDB) the tool used to initialize a fresh
+// datadir, so Start needs to know which one it's talking to.
+type Flavor string
+
+const (
+	// MySQL is Oracle's mysqld. This is the default Flavor.
+	MySQL Flavor = "mysql"
+	// MariaDB is MariaDB's mysqld/mariadbd.
+	MariaDB Flavor = "mariadb"
+	// Percona is Percona Server's mysqld.
+	Percona Flavor = "percona"
+)
+
+// binary returns the default binary name for f, used when Server.Binary is
+// left unset.
+func (f Flavor) binary() string {
+	if f == MariaDB {
+		return "mariadbd"
+	}
+	return "mysqld"
+}
+
+// readySentinel is the banner text the server writes to stderr once it is
+// accepting connections.
+func (f Flavor) readySentinel() []byte {
+	if f == MariaDB {
+		return []byte("mariadbd: ready for connections")
+	}
+	return []byte("mysqld: ready for connections")
+}
+
+// bootstrapCmd returns the command used to initialize a fresh datadir for
+// f. MySQL 5.7+/8.0 and recent MariaDB (10.4+) support --initialize-insecure
+// directly; older MariaDB needs the separate mysql_install_db script.
+func (f Flavor) bootstrapCmd(binary, version, defaultsFile, baseDirArg string) *exec.Cmd {
+	if f == MariaDB && !mariaDBSupportsInitializeInsecure(version) {
+		return exec.Command("mysql_install_db", defaultsFile, baseDirArg)
+	}
+	return exec.Command(binary, defaultsFile, "--initialize-insecure", baseDirArg)
+}
+
+// mariaDBSupportsInitializeInsecure reports whether version (e.g.
+// "10.3.34") is new enough to bootstrap via --initialize-insecure rather
+// than the legacy mysql_install_db script. MariaDB gained
+// --initialize-insecure in 10.4. An empty version is assumed to be modern
+// enough.
+func mariaDBSupportsInitializeInsecure(version string) bool {
+	if version == "" {
+		return true
+	}
+	var major, minor int
+	if _, err := fmt.Sscanf(version, "%d.%d", &major, &minor); err != nil {
+		return true
+	}
+	return major > 10 || (major == 10 && minor >= 4)
+}
+
+var basedirCache sync.Map // binary (string) -> basedir (string)
+
+// basedirFor probes binary for its compiled-in basedir via
+// `<binary> --help --verbose`, the same way the package used to probe a
+// single "mysqld" on PATH. Results are cached per binary so a Pool or
+// Cluster of same-flavor servers only pays the probe once.
+func basedirFor(binary string) (string, error) {
+	if v, ok := basedirCache.Load(binary); ok {
+		return v.(string), nil
+	}
+
+	out, err := exec.Command(binary, "--help", "--verbose").CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("probing basedir for %s: %v", binary, err)
+	}
+
+	// The spaces are important.
+	hit := regexp.MustCompile(`basedir        .*`).Find(out)
+	if hit == nil {
+		return "", fmt.Errorf("could not find basedir in %s --help --verbose output", binary)
+	}
+	dir := string(bytes.TrimSpace(hit[8:]))
+	basedirCache.Store(binary, dir)
+	return dir, nil
+}
@@ -5,6 +5,7 @@ package mysqltest
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"database/sql"
 	"fmt"
 	"io"
@@ -12,20 +13,15 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
-	// We're optionally provide a DB instance backed by this driver.
-	_ "github.com/go-sql-driver/mysql"
-
 	"github.com/facebookgo/freeport"
 	"github.com/facebookgo/waitout"
 )
 
-var mysqlReadyForConnections = []byte("mysqld: ready for connections")
-
 var configTemplate, configTemplateErr = template.New("config").Parse(`
 [mysqld]
 bind-address                    = 127.0.0.1
@@ -46,23 +42,28 @@ sql_mode                        = ''
 thread_cache_size               = 2
 thread_stack                    = 128K
 user                            = root
-`)
-
-var mysqlBaseDir string
+{{if .ServerID}}
+server-id                        = {{.ServerID}}
+{{end}}
+{{if .LogBin}}
+log-bin                           = mysql-bin
+binlog_format                     = ROW
+{{end}}
+{{if .GTID}}
+gtid_mode                        = ON
+enforce_gtid_consistency         = ON
+{{end}}
+{{if .TLS}}
+ssl-ca                           = {{.SSLCAPath}}
+ssl-cert                         = {{.SSLCertPath}}
+ssl-key                          = {{.SSLKeyPath}}
+require_secure_transport         = ON
+{{end}}`)
 
 func init() {
 	if configTemplateErr != nil {
 		panic(configTemplateErr)
 	}
-
-	out, err := exec.Command("mysqld", "--help", "--verbose").CombinedOutput()
-	if err != nil {
-		panic(err)
-	}
-
-	// The spaces are important.
-	hit := regexp.MustCompile(`basedir        .*`).Find(out)
-	mysqlBaseDir = string(bytes.TrimSpace(hit[8:]))
 }
 
 // Fatalf is satisfied by testing.T or testing.B.
@@ -76,68 +77,267 @@ type Server struct {
 	DataDir string
 	Socket  string
 	T       Fatalf
-	cmd     *exec.Cmd
+
+	// Flavor selects the server implementation to run (MySQL, MariaDB,
+	// Percona). It defaults to MySQL.
+	Flavor Flavor
+	// Version is the server's version, e.g. "10.4.12". It's only used to
+	// pick flavor-appropriate bootstrap behavior (see Flavor.bootstrapCmd)
+	// and, in NewCluster, replication syntax and GTID use; it may be left
+	// blank.
+	Version string
+	// Binary overrides the mysqld binary invoked for this server, so
+	// multiple installed flavors/versions can be addressed by path instead
+	// of relying on a single binary on PATH. Defaults to Flavor.binary().
+	Binary string
+
+	// TLS enables a throwaway CA and server certificate, rejecting
+	// non-secure connections. The CA is registered with
+	// mysql.RegisterTLSConfig under the name returned by TLSName.
+	TLS bool
+
+	// Accounts are additional MySQL users provisioned once the server is
+	// ready for connections, beyond the default passwordless root.
+	Accounts []Account
+
+	// ServerID sets the replication server-id. It must be unique within a
+	// Cluster; left at 0 it's omitted from the config.
+	ServerID int
+	// LogBin enables binary logging (log-bin, binlog_format=ROW), required
+	// on a Cluster's primary.
+	LogBin bool
+	// GTID enables gtid_mode/enforce_gtid_consistency, used by NewCluster
+	// for MySQL primaries/replicas new enough to support GTID-based
+	// auto-positioning instead of an explicit binlog file+position.
+	GTID bool
+
+	// seedDataDir, if set, is copied into DataDir in place of the usual
+	// --initialize-insecure/mysql_install_db bootstrap. Set by
+	// NewServerFromSnapshot.
+	seedDataDir string
+	// cnfPath and baseDir are recorded by StartContext so launchContext can restart
+	// mysqld in place (used by Snapshot and Restore) without redoing
+	// basedir discovery or config rendering.
+	cnfPath string
+	baseDir string
+
+	cmd *exec.Cmd
+}
+
+// binary returns the mysqld binary to invoke for this server.
+func (s *Server) binary() string {
+	if s.Binary != "" {
+		return s.Binary
+	}
+	return s.Flavor.binary()
 }
 
-// Start the server, this will return once the server has been started.
+// Start the server, this will return once the server has been started. It
+// calls T.Fatalf on error and has no bound on how long it will wait for
+// mysqld to report ready for connections; prefer StartContext for that.
 func (s *Server) Start() {
+	if err := s.StartContext(context.Background()); err != nil {
+		s.T.Fatalf(err.Error())
+	}
+}
+
+// StartContext starts the server, returning once it's ready for
+// connections or ctx is done. If ctx is done first, the mysqld process (if
+// any was started) is killed and the returned error includes the captured
+// stderr tail. ctx is only checked while waiting for mysqld to report ready;
+// bootstrapping a fresh datadir beforehand runs to completion regardless.
+func (s *Server) StartContext(ctx context.Context) error {
 	port, err := freeport.Get()
 	if err != nil {
-		s.T.Fatalf(err.Error())
+		return err
 	}
 	s.Port = port
 
 	dir, err := ioutil.TempDir("", "mysql-datadir-")
 	if err != nil {
-		s.T.Fatalf(err.Error())
+		return err
 	}
 	s.DataDir = filepath.Join(dir, "data")
 	s.Socket = filepath.Join(dir, "socket")
 
+	if s.TLS {
+		if err := s.setupTLS(); err != nil {
+			return err
+		}
+	}
+
+	if s.seedDataDir != "" {
+		if err := copyDir(s.seedDataDir, s.DataDir); err != nil {
+			return err
+		}
+	}
+
 	cf, err := os.Create(filepath.Join(dir, "my.cnf"))
 	if err != nil {
-		s.T.Fatalf(err.Error())
+		return err
 	}
 	if err := configTemplate.Execute(cf, s); err != nil {
-		s.T.Fatalf(err.Error())
+		return err
 	}
 	if err := cf.Close(); err != nil {
-		s.T.Fatalf(err.Error())
+		return err
 	}
+	s.cnfPath = cf.Name()
 
-	defaultsFile := fmt.Sprintf("--defaults-file=%s", cf.Name())
-	baseDir := fmt.Sprintf("--basedir=%s", mysqlBaseDir)
-	s.cmd = exec.Command("mysqld", defaultsFile, "--initialize-insecure", baseDir)
-	if os.Getenv("MYSQLTEST_VERBOSE") == "1" {
-		s.cmd.Stdout = os.Stdout
-		s.cmd.Stderr = os.Stderr
+	binary := s.binary()
+	baseDir, err := basedirFor(binary)
+	if err != nil {
+		return err
 	}
-	if err := s.cmd.Run(); err != nil {
-		s.T.Fatalf(err.Error())
+	s.baseDir = baseDir
+
+	if s.seedDataDir == "" {
+		defaultsFile := fmt.Sprintf("--defaults-file=%s", s.cnfPath)
+		baseDirArg := fmt.Sprintf("--basedir=%s", baseDir)
+		bootstrap := s.Flavor.bootstrapCmd(binary, s.Version, defaultsFile, baseDirArg)
+		var stderr bytes.Buffer
+		if os.Getenv("MYSQLTEST_VERBOSE") == "1" {
+			bootstrap.Stdout = os.Stdout
+			bootstrap.Stderr = io.MultiWriter(os.Stderr, &stderr)
+		} else {
+			bootstrap.Stderr = &stderr
+		}
+		if err := bootstrap.Run(); err != nil {
+			return fmt.Errorf("bootstrapping %s: %v; stderr: %s", binary, err, stderr.String())
+		}
 	}
 
-	waiter := waitout.New(mysqlReadyForConnections)
-	s.cmd = exec.Command("mysqld", defaultsFile, "--basedir", mysqlBaseDir)
+	if err := s.launchContext(ctx); err != nil {
+		return err
+	}
+
+	// From here mysqld is a live process holding DataDir open; any
+	// further error must tear both down rather than leaking them, so
+	// cleanup runs on every path out of this function except success.
+	succeeded := false
+	defer func() {
+		if !succeeded {
+			s.stopProcess()
+			os.RemoveAll(filepath.Dir(s.DataDir))
+		}
+	}()
+
+	if s.seedDataDir == "" {
+		if err := s.createAccounts(); err != nil {
+			return err
+		}
+	}
+	succeeded = true
+	return nil
+}
+
+// launchContext execs mysqld against the already-prepared DataDir/config
+// and blocks until it reports ready for connections or ctx is done. Used
+// both by StartContext and to restart a server in place after
+// Snapshot/Restore.
+func (s *Server) launchContext(ctx context.Context) error {
+	binary := s.binary()
+	defaultsFile := fmt.Sprintf("--defaults-file=%s", s.cnfPath)
+
+	var stderr bytes.Buffer
+	waiter := waitout.New(s.Flavor.readySentinel())
+	stderrWriter := io.MultiWriter(&stderr, waiter)
+
+	s.cmd = exec.Command(binary, defaultsFile, "--basedir", s.baseDir)
 	if os.Getenv("MYSQLTEST_VERBOSE") == "1" {
 		s.cmd.Stdout = os.Stdout
-		s.cmd.Stderr = io.MultiWriter(os.Stderr, waiter)
+		s.cmd.Stderr = io.MultiWriter(os.Stderr, stderrWriter)
 	} else {
-		s.cmd.Stderr = waiter
+		s.cmd.Stderr = stderrWriter
 	}
 	if err := s.cmd.Start(); err != nil {
-		s.T.Fatalf(err.Error())
+		return err
+	}
+
+	ready := make(chan struct{})
+	go func() {
+		waiter.Wait()
+		close(ready)
+	}()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		s.cmd.Process.Kill()
+		s.cmd.Wait()
+		return fmt.Errorf("waiting for %s ready for connections: %v; stderr: %s", binary, ctx.Err(), stderr.String())
+	}
+}
+
+// stopProcess stops the running mysqld cleanly (mysqladmin shutdown,
+// falling back to SIGTERM), killing it if it hasn't exited within
+// stopTimeout, and waits for it to exit, without touching DataDir. Used by
+// StartContext to tear down a failed start and by Snapshot/Restore (which
+// need the process down before copying DataDir).
+func (s *Server) stopProcess() {
+	if s.cmd == nil || s.cmd.Process == nil {
+		return
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- s.cmd.Wait() }()
+
+	shutdown := exec.Command("mysqladmin", fmt.Sprintf("--socket=%s", s.Socket), "-u", "root", "shutdown")
+	if err := shutdown.Run(); err != nil {
+		s.cmd.Process.Signal(syscall.SIGTERM)
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(stopTimeout):
+		s.cmd.Process.Kill()
+		<-exited
 	}
-	waiter.Wait()
 }
 
-// Stop the server, this will also remove all data.
+// stopTimeout bounds Stop's wait for a clean mysqld shutdown before it
+// falls back to killing the process.
+const stopTimeout = 10 * time.Second
+
+// Stop the server, this will also remove all data. Prefer Shutdown for
+// an explicit bound on how long to wait before falling back to a kill.
 func (s *Server) Stop() {
-	s.cmd.Process.Kill()
-	os.RemoveAll(s.DataDir)
+	ctx, cancel := context.WithTimeout(context.Background(), stopTimeout)
+	defer cancel()
+	s.Shutdown(ctx)
+}
+
+// Shutdown stops mysqld cleanly (mysqladmin shutdown, falling back to
+// SIGTERM if that fails to even start) and waits for it to exit, then
+// removes the server's temp dir (data, socket, my.cnf, and any TLS
+// material). If ctx is done before the process exits, it is killed
+// instead, so everything is removed deterministically either way.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.cmd == nil || s.cmd.Process == nil {
+		return os.RemoveAll(filepath.Dir(s.DataDir))
+	}
+
+	exited := make(chan error, 1)
+	go func() { exited <- s.cmd.Wait() }()
+
+	shutdown := exec.Command("mysqladmin", fmt.Sprintf("--socket=%s", s.Socket), "-u", "root", "shutdown")
+	if err := shutdown.Run(); err != nil {
+		s.cmd.Process.Signal(syscall.SIGTERM)
+	}
+
+	select {
+	case <-exited:
+	case <-ctx.Done():
+		s.cmd.Process.Kill()
+		<-exited
+	}
+
+	return os.RemoveAll(filepath.Dir(s.DataDir))
 }
 
 // DSN for the mysql server, suitable for use with sql.Open. The suffix is in
-// the form "dbname?param=value".
+// the form "dbname?param=value". If TLS is enabled, pass
+// "tls="+s.TLSName() as one of those params to use the generated CA.
 func (s *Server) DSN(suffix string) string {
 	return fmt.Sprintf("root@tcp(127.0.0.1:%d)/%s", s.Port, suffix)
 }
@@ -151,53 +351,100 @@ func (s *Server) DB(suffix string) *sql.DB {
 	return db
 }
 
-// Load takes sql statements from reader r and applies them to database db.
-// Statements are delimited by a semicolon.
-func Load(db *sql.DB, r io.Reader) error {
+// rootDB opens a root connection over the Unix socket rather than TCP.
+// Internal helpers that need a root connection before a client has any say
+// over TLS (createAccounts, resetSchemas, replication wiring) use this
+// instead of DSN/DB, since a Unix socket connection satisfies
+// require_secure_transport on its own and so isn't rejected when TLS is
+// enabled.
+func (s *Server) rootDB(suffix string) (*sql.DB, error) {
+	return sql.Open("mysql", fmt.Sprintf("root@unix(%s)/%s", s.Socket, suffix))
+}
 
+// Load takes SQL statements from reader r and applies them to database db.
+// It understands output produced by mysqldump: DELIMITER directives (used
+// to safely embed semicolons inside triggers/procedures), comment lines
+// starting with "--" or "#", and /*! ... */ version-gated hints, which are
+// sent through unmodified since the server strips the markers itself.
+// Statements are otherwise delimited by the current delimiter, ";" unless
+// changed by a DELIMITER directive.
+func Load(db *sql.DB, r io.Reader) error {
 	scanner := bufio.NewScanner(r)
-	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-		if i := bytes.IndexByte(data, ';'); i >= 0 {
-			return i + 1, data[0:i], nil
-		}
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	delimiter := ";"
+	var stmt strings.Builder
 
-		if atEOF {
-			return len(data), data, nil
+	flush := func() error {
+		s := strings.TrimSpace(stmt.String())
+		stmt.Reset()
+		if s == "" {
+			return nil
 		}
-		return 0, nil, nil
-	})
+		if _, err := db.Exec(s); err != nil {
+			return fmt.Errorf("%q failed: %v", s, err)
+		}
+		return nil
+	}
 
 	for scanner.Scan() {
-		stmt := scanner.Text()
-		stmt = strings.Trim(stmt, " \n\r")
-		if stmt == "" {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		if strings.HasPrefix(strings.ToUpper(trimmed), "DELIMITER ") {
+			if err := flush(); err != nil {
+				return err
+			}
+			delimiter = strings.TrimSpace(trimmed[len("DELIMITER "):])
 			continue
 		}
-		_, err := db.Exec(stmt)
-		if err != nil {
-			return fmt.Errorf("\"%s\" failed: %v", stmt, err)
+
+		stmt.WriteString(line)
+		stmt.WriteByte('\n')
+
+		for {
+			buffered := stmt.String()
+			idx := strings.Index(buffered, delimiter)
+			if idx < 0 {
+				break
+			}
+			before, after := buffered[:idx], buffered[idx+len(delimiter):]
+			stmt.Reset()
+			stmt.WriteString(before)
+			if err := flush(); err != nil {
+				return err
+			}
+			stmt.WriteString(after)
 		}
 	}
 
+	if err := flush(); err != nil {
+		return err
+	}
 	return scanner.Err()
 }
 
-// NewStartedServer creates a new server starts it.
+// NewStartedServer creates a new server and starts it, retrying if it
+// doesn't become ready within 30 seconds. Each attempt uses StartContext,
+// so an attempt that times out has its mysqld process killed before the
+// next one begins, rather than being abandoned to run forever. Any other
+// error (missing binary, bad basedir, a TLS or account setup failure) is
+// permanent and calls t.Fatalf immediately rather than being retried.
 func NewStartedServer(t Fatalf) *Server {
 	for {
 		s := &Server{T: t}
-		start := make(chan struct{})
-		go func() {
-			defer close(start)
-			s.Start()
-		}()
-		select {
-		case <-start:
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := s.StartContext(ctx)
+		cancel()
+		if err == nil {
 			return s
-		case <-time.After(30 * time.Second):
+		}
+		if ctx.Err() != context.DeadlineExceeded {
+			t.Fatalf(err.Error())
+			return nil
 		}
 	}
 }
@@ -206,7 +453,12 @@ func NewStartedServer(t Fatalf) *Server {
 // returns both.
 func NewServerDB(t Fatalf, db string) (*Server, *sql.DB) {
 	s := NewStartedServer(t)
-	if _, err := s.DB("").Exec("create database " + db); err != nil {
+	root, err := s.rootDB("")
+	if err != nil {
+		t.Fatalf(err.Error())
+	}
+	defer root.Close()
+	if _, err := root.Exec("create database " + db); err != nil {
 		t.Fatalf(err.Error())
 	}
 	return s, s.DB(db)
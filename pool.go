@@ -0,0 +1,128 @@
+package mysqltest
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// PoolOptions configures NewPool.
+type PoolOptions struct {
+	// Size is the number of pre-warmed Servers to keep running. It
+	// defaults to the MYSQL_TEST_CONCURRENT env var if set, else 4.
+	Size int
+	// New constructs each pooled Server before it's started, so callers
+	// can set Flavor, TLS, Accounts, etc. It defaults to &Server{}.
+	New func() *Server
+}
+
+func (o PoolOptions) size() int {
+	if o.Size > 0 {
+		return o.Size
+	}
+	if v := os.Getenv("MYSQL_TEST_CONCURRENT"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			return n
+		}
+	}
+	return 4
+}
+
+func (o PoolOptions) newServer() *Server {
+	if o.New != nil {
+		return o.New()
+	}
+	return &Server{}
+}
+
+// Pool keeps a fixed number of pre-warmed Servers running and hands them
+// out to parallel tests, resetting state between uses by dropping all
+// non-system schemas rather than restarting mysqld.
+type Pool struct {
+	servers chan *Server
+}
+
+// NewPool starts opts.Size (or MYSQL_TEST_CONCURRENT, or 4) Servers and
+// returns a Pool ready to Acquire from.
+func NewPool(t Fatalf, opts PoolOptions) *Pool {
+	n := opts.size()
+	p := &Pool{servers: make(chan *Server, n)}
+	for i := 0; i < n; i++ {
+		s := opts.newServer()
+		s.T = t
+		s.Start()
+		p.servers <- s
+	}
+	return p
+}
+
+// Acquire checks out a Server for use by t, blocking until one is
+// available. t.Parallel() tests can each Acquire their own. Call Release
+// when t is done with it.
+func (p *Pool) Acquire(t Fatalf) *Server {
+	s := <-p.servers
+	s.T = t
+	return s
+}
+
+// Release resets s's state (dropping all non-system schemas) and returns
+// it to the pool for reuse.
+func (p *Pool) Release(s *Server) {
+	if err := resetSchemas(s); err != nil {
+		s.T.Fatalf(err.Error())
+	}
+	p.servers <- s
+}
+
+// Close stops every Server in the pool. The Pool must not be used
+// afterwards.
+func (p *Pool) Close() {
+	close(p.servers)
+	for s := range p.servers {
+		s.Stop()
+	}
+}
+
+var systemSchemas = map[string]bool{
+	"mysql":              true,
+	"information_schema": true,
+	"performance_schema": true,
+	"sys":                true,
+}
+
+// resetSchemas drops every non-system schema on s, returning it to a clean
+// state without restarting mysqld.
+func resetSchemas(s *Server) error {
+	db, err := s.rootDB("")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("SHOW DATABASES")
+	if err != nil {
+		return fmt.Errorf("listing databases: %v", err)
+	}
+	var schemas []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			rows.Close()
+			return err
+		}
+		if !systemSchemas[name] {
+			schemas = append(schemas, name)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	for _, name := range schemas {
+		if _, err := db.Exec(fmt.Sprintf("DROP DATABASE `%s`", name)); err != nil {
+			return fmt.Errorf("dropping database %s: %v", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package mysqltest
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Snapshot is a point-in-time copy of a Server's datadir, suitable for
+// seeding new servers via NewServerFromSnapshot, or restoring back onto an
+// existing Server via Restore, without paying the bootstrap cost again.
+type Snapshot struct {
+	dataDir string
+}
+
+// Snapshot cleanly shuts down s, copies its datadir aside, and restarts s
+// in place (same port, socket, and config). ctx bounds the restart; if
+// mysqld never reports ready, its process is killed and the error
+// includes the captured stderr tail.
+func (s *Server) Snapshot(ctx context.Context) (*Snapshot, error) {
+	s.stopProcess()
+
+	dir, err := ioutil.TempDir("", "mysql-snapshot-")
+	if err != nil {
+		return nil, err
+	}
+	dataDir := filepath.Join(dir, "data")
+	if err := copyDir(s.DataDir, dataDir); err != nil {
+		return nil, err
+	}
+
+	if err := s.launchContext(ctx); err != nil {
+		return nil, fmt.Errorf("restarting %s after snapshot: %v", s.DataDir, err)
+	}
+	return &Snapshot{dataDir: dataDir}, nil
+}
+
+// Restore cleanly shuts down s, replaces its datadir with snap's contents,
+// and restarts s in place. ctx bounds the restart; if mysqld never
+// reports ready, its process is killed and the error includes the
+// captured stderr tail.
+func (s *Server) Restore(ctx context.Context, snap *Snapshot) error {
+	s.stopProcess()
+
+	if err := os.RemoveAll(s.DataDir); err != nil {
+		return err
+	}
+	if err := copyDir(snap.dataDir, s.DataDir); err != nil {
+		return err
+	}
+
+	if err := s.launchContext(ctx); err != nil {
+		return fmt.Errorf("restarting %s after restore: %v", s.DataDir, err)
+	}
+	return nil
+}
+
+// NewServerFromSnapshot creates and starts a fresh Server seeded from
+// snap's datadir, skipping the usual
+// --initialize-insecure/mysql_install_db bootstrap.
+func NewServerFromSnapshot(t Fatalf, snap *Snapshot) *Server {
+	s := &Server{T: t, seedDataDir: snap.dataDir}
+	s.Start()
+	return s
+}
+
+// copyDir recursively copies the contents of src into dst, creating dst as
+// needed.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, info.Mode())
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(target, data, info.Mode())
+	})
+}
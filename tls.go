@@ -0,0 +1,111 @@
+package mysqltest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// SSLCAPath, SSLCertPath, and SSLKeyPath are where setupTLS writes the
+// throwaway CA/certificate/key used when TLS is enabled. They live
+// alongside the datadir so Stop cleans them up along with everything else.
+func (s *Server) SSLCAPath() string { return filepath.Join(filepath.Dir(s.DataDir), "ca.pem") }
+func (s *Server) SSLCertPath() string {
+	return filepath.Join(filepath.Dir(s.DataDir), "server-cert.pem")
+}
+func (s *Server) SSLKeyPath() string { return filepath.Join(filepath.Dir(s.DataDir), "server-key.pem") }
+
+// TLSName is the name this server's CA is registered under via
+// mysql.RegisterTLSConfig, for use as a DSN "tls" parameter, e.g.
+// s.DSN("db?tls=" + s.TLSName()). It's empty unless TLS is enabled.
+func (s *Server) TLSName() string {
+	if !s.TLS {
+		return ""
+	}
+	return fmt.Sprintf("mysqltest-%d", s.Port)
+}
+
+// setupTLS generates a throwaway CA and a server certificate signed by it
+// into the datadir's parent directory, then registers the CA with
+// mysql.RegisterTLSConfig under TLSName so client connections can verify
+// the server. It must run after s.Port and s.DataDir are assigned and
+// before the config file referencing SSLCAPath et al. is written.
+func (s *Server) setupTLS() error {
+	caKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("generating CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "mysqltest CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("creating CA certificate: %v", err)
+	}
+	caCert, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		return fmt.Errorf("parsing CA certificate: %v", err)
+	}
+
+	serverKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return fmt.Errorf("generating server key: %v", err)
+	}
+	serverTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	serverDER, err := x509.CreateCertificate(rand.Reader, serverTemplate, caCert, &serverKey.PublicKey, caKey)
+	if err != nil {
+		return fmt.Errorf("creating server certificate: %v", err)
+	}
+
+	if err := writeCertPEM(s.SSLCAPath(), caDER); err != nil {
+		return err
+	}
+	if err := writeCertPEM(s.SSLCertPath(), serverDER); err != nil {
+		return err
+	}
+	if err := writeKeyPEM(s.SSLKeyPath(), serverKey); err != nil {
+		return err
+	}
+
+	pool := x509.NewCertPool()
+	pool.AddCert(caCert)
+	return mysql.RegisterTLSConfig(s.TLSName(), &tls.Config{
+		RootCAs:    pool,
+		ServerName: "127.0.0.1",
+	})
+}
+
+func writeCertPEM(path string, der []byte) error {
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+}
+
+func writeKeyPEM(path string, key *rsa.PrivateKey) error {
+	der := x509.MarshalPKCS1PrivateKey(key)
+	return ioutil.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: der}), 0600)
+}